@@ -0,0 +1,155 @@
+/*
+*************************
+before
+CheckoutUsecase.Checkout や Order.DecideCheckout は errors.New("invalid status") のような
+ただの文字列エラーを返している。
+
+呼び出し側（HTTPハンドラ）はこれを受け取っても：
+・ユーザーに見せてよい業務エラーなのか
+・ログだけ残して500を返すべき技術的失敗なのか
+を区別できない。結局すべて500にするか、メッセージをそのままレスポンスに漏らすか、
+という雑な二択になってしまう。
+*************************
+*/
+func (o Order) DecideCheckout(now time.Time) (CheckoutDecision, error) {
+    if o.Status != StatusPending {
+        return CheckoutDecision{}, errors.New("invalid status")
+    }
+    if now.After(o.ExpireAt) {
+        return CheckoutDecision{}, errors.New("expired")
+    }
+    return CheckoutDecision{NextStatus: StatusPaid, NeedCharge: true}, nil
+}
+
+func handleCheckout(w http.ResponseWriter, err error) {
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+/*
+「ユーザーに見せるべき業務エラー」と「ログに残すだけでよい技術的失敗」は
+性質が全く違うのに、同じ error インターフェースに押し込めているのが問題。
+ドメイン層で判断し、翻訳はアダプタ層に任せる形にする。
+*************************
+after
+errors パッケージ（ドメイン/ユースケースが依存する、技術詳細を持たない層）
+*************************
+*/
+
+// ErrorType はドメインエラーの種別。呼び出し側はこれを見て表示やステータスコードを決める
+type ErrorType string
+
+const (
+    InvalidParameter ErrorType = "invalid_parameter"
+    Unpermitted       ErrorType = "unpermitted"
+    NotFound          ErrorType = "not_found"
+    AlreadyExists     ErrorType = "already_exists"
+    Conflict          ErrorType = "conflict"
+)
+
+// DomainError はユーザーに見せてよい業務エラー
+type DomainError struct {
+    Type    ErrorType
+    Message string
+    Cause   error
+}
+
+func NewDomainError(t ErrorType, message string, cause error) *DomainError {
+    return &DomainError{Type: t, Message: message, Cause: cause}
+}
+
+func (e *DomainError) Error() string {
+    if e.Cause != nil {
+        return e.Message + ": " + e.Cause.Error()
+    }
+    return e.Message
+}
+
+func (e *DomainError) Unwrap() error { return e.Cause }
+
+// InfraError はDB・外部API・ネットワークなど技術的な失敗。ユーザーには詳細を見せない
+type InfraError struct {
+    Op    string
+    Cause error
+}
+
+func NewInfraError(op string, cause error) *InfraError {
+    return &InfraError{Op: op, Cause: cause}
+}
+
+func (e *InfraError) Error() string {
+    return e.Op + ": " + e.Cause.Error()
+}
+
+func (e *InfraError) Unwrap() error { return e.Cause }
+
+// ドメイン層は DomainError を組み立てるだけで、HTTPステータスなどは一切知らない
+func (o Order) DecideCheckout(now time.Time) (CheckoutDecision, error) {
+    if o.Status != StatusPending {
+        return CheckoutDecision{}, NewDomainError(Conflict, "注文は決済待ち状態ではありません", nil)
+    }
+    if now.After(o.ExpireAt) {
+        return CheckoutDecision{}, NewDomainError(Conflict, "注文の有効期限が切れています", nil)
+    }
+    return CheckoutDecision{NextStatus: StatusPaid, NeedCharge: true}, nil
+}
+
+func (uc *CheckoutUsecase) Checkout(ctx context.Context, orderID string) error {
+    order, err := uc.orderRepo.Find(ctx, orderID)
+    if err != nil {
+        return NewInfraError("orderRepo.Find", err)
+    }
+
+    decision, err := order.DecideCheckout(uc.clock.Now())
+    if err != nil {
+        return err
+    }
+
+    if decision.NeedCharge {
+        if err := uc.payment.Charge(ctx, order.CardToken, order.Amount); err != nil {
+            return NewInfraError("payment.Charge", err)
+        }
+    }
+
+    if err := uc.orderRepo.UpdateStatus(ctx, order.ID, decision.NextStatus); err != nil {
+        return NewInfraError("orderRepo.UpdateStatus", err)
+    }
+
+    return nil
+}
+
+// HTTPアダプタ（外側）：DomainError→4xx、InfraError→500 の翻訳だけを行う。
+// ドメイン/ユースケースはこの関数の存在を知らない
+func WriteCheckoutError(w http.ResponseWriter, logger *log.Logger, err error) {
+    var de *DomainError
+    if errors.As(err, &de) {
+        http.Error(w, de.Message, domainErrorStatusCode(de.Type))
+        return
+    }
+
+    var ie *InfraError
+    if errors.As(err, &ie) {
+        logger.Printf("infra error: %v", ie)
+        http.Error(w, "internal server error", http.StatusInternalServerError)
+        return
+    }
+
+    logger.Printf("unexpected error: %v", err)
+    http.Error(w, "internal server error", http.StatusInternalServerError)
+}
+
+func domainErrorStatusCode(t ErrorType) int {
+    switch t {
+    case InvalidParameter:
+        return http.StatusBadRequest
+    case Unpermitted:
+        return http.StatusForbidden
+    case NotFound:
+        return http.StatusNotFound
+    case AlreadyExists, Conflict:
+        return http.StatusConflict
+    default:
+        return http.StatusBadRequest
+    }
+}
@@ -0,0 +1,161 @@
+/*
+*************************
+before
+CheckoutUsecase.Checkout は orderRepo.UpdateStatus と publisher.Publish を
+別々に呼んでいる。Publish が失敗しても UpdateStatus はもう確定済みで、
+注文は「paid」のままイベントだけ飛んでいない、という不整合な状態になりうる。
+
+OrderRepository・EventPublisher はそれぞれ別の実装（別のDB接続やクライアント）を
+持ちうるので、usecase側でまとめて1トランザクションにする手段がない。
+*************************
+*/
+func (uc *CheckoutUsecase) Checkout(ctx context.Context, orderID string) error {
+    order, err := uc.orderRepo.Find(ctx, orderID)
+    if err != nil {
+        return err
+    }
+
+    decision, err := order.DecideCheckout(uc.clock.Now())
+    if err != nil {
+        return err
+    }
+
+    if decision.NeedCharge {
+        if err := uc.payment.Charge(ctx, order.CardToken, order.Amount); err != nil {
+            return err
+        }
+    }
+
+    // この2つがバラバラに実行される。Publishだけ失敗すると注文とイベントがズレる
+    if err := uc.orderRepo.UpdateStatus(ctx, order.ID, decision.NextStatus); err != nil {
+        return err
+    }
+    return uc.publisher.Publish(ctx, "order.paid", map[string]any{"order_id": order.ID})
+}
+
+/*
+UpdateStatus と Publish は「どちらも成功する」か「どちらも失敗する」かであってほしい。
+そこで、usecase が database/sql を直接知ることなく「ここからここまでは1つの単位」と
+宣言できる UnitOfWork を OrderRepository と同じ層に置く。
+payment.Charge は外部APIであり、DBトランザクションには参加できないので
+明示的にUnitOfWorkの外に置いたままにする。
+*************************
+after
+*************************
+*/
+
+// UnitOfWork は「複数のリポジトリ/ゲートウェイ呼び出しを1つの単位として扱う」ための抽象。
+// OrderRepository と同じ層に置き、usecase は database/sql を知らないまま使う
+type UnitOfWork interface {
+    Do(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// SQLUnitOfWork は UnitOfWork の本番実装。*sql.Tx を context に積んで fn に渡す
+type SQLUnitOfWork struct {
+    db *sql.DB
+}
+
+func NewSQLUnitOfWork(db *sql.DB) *SQLUnitOfWork {
+    return &SQLUnitOfWork{db: db}
+}
+
+type txKey struct{}
+
+func (u *SQLUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+    tx, err := u.db.BeginTx(ctx, nil)
+    if err != nil {
+        return NewInfraError("uow.BeginTx", err)
+    }
+
+    txCtx := context.WithValue(ctx, txKey{}, tx)
+
+    if err := fn(txCtx); err != nil {
+        if rbErr := tx.Rollback(); rbErr != nil {
+            // ロールバック自体の失敗だけでなく、ロールバックの原因になった元のエラーも
+            // 一緒に返す。どちらかを握りつぶすと本当の失敗理由が呼び出し元やログから消える
+            return NewInfraError("uow.Rollback", errors.Join(err, rbErr))
+        }
+        return err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return NewInfraError("uow.Commit", err)
+    }
+    return nil
+}
+
+// txFromContext は SQLUnitOfWork.Do の中でだけ *sql.Tx を取り出せるヘルパー
+func txFromContext(ctx context.Context) (*sql.Tx, bool) {
+    tx, ok := ctx.Value(txKey{}).(*sql.Tx)
+    return tx, ok
+}
+
+// TxOrderRepository は OrderRepository の実装。UpdateStatus は context に Tx があれば
+// それに乗り、なければ db に直接発行する（UnitOfWorkの外からも呼べるようにするため）。
+// Find は context の Tx を見ず、常に db から読む
+type TxOrderRepository struct {
+    db *sql.DB
+}
+
+func NewTxOrderRepository(db *sql.DB) *TxOrderRepository {
+    return &TxOrderRepository{db: db}
+}
+
+func (r *TxOrderRepository) queryer(ctx context.Context) interface {
+    ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+} {
+    if tx, ok := txFromContext(ctx); ok {
+        return tx
+    }
+    return r.db
+}
+
+func (r *TxOrderRepository) Find(ctx context.Context, id string) (Order, error) {
+    return loadOrderFromDB(ctx, r.db, id)
+}
+
+func (r *TxOrderRepository) UpdateStatus(ctx context.Context, id string, status OrderStatus) error {
+    _, err := r.queryer(ctx).ExecContext(ctx, `UPDATE orders SET status = ? WHERE id = ?`, status, id)
+    return err
+}
+
+// CheckoutUsecase は UnitOfWork を受け取り、「状態更新」と「イベント発行」を
+// 1つの原子的な単位として扱う。payment.Charge はここには含めない
+type CheckoutUsecase struct {
+    uow       UnitOfWork
+    orderRepo OrderRepository
+    payment   PaymentGateway
+    publisher EventPublisher
+    clock     Clock
+}
+
+func (uc *CheckoutUsecase) Checkout(ctx context.Context, orderID string) error {
+    order, err := uc.orderRepo.Find(ctx, orderID)
+    if err != nil {
+        return NewInfraError("orderRepo.Find", err)
+    }
+
+    decision, err := order.DecideCheckout(uc.clock.Now())
+    if err != nil {
+        return err
+    }
+
+    // 外部APIはトランザクションに参加できないので、明示的に外側で呼ぶ
+    if decision.NeedCharge {
+        if err := uc.payment.Charge(ctx, order.CardToken, order.Amount); err != nil {
+            return NewInfraError("payment.Charge", err)
+        }
+    }
+
+    // UpdateStatus と Publish はここで初めて「1つの単位」になる。
+    // Publish が失敗すれば UpdateStatus もロールバックされる
+    return uc.uow.Do(ctx, func(ctx context.Context) error {
+        if err := uc.orderRepo.UpdateStatus(ctx, order.ID, decision.NextStatus); err != nil {
+            return NewInfraError("orderRepo.UpdateStatus", err)
+        }
+        if err := uc.publisher.Publish(ctx, "order.paid", map[string]any{"order_id": order.ID}); err != nil {
+            return NewInfraError("publisher.Publish", err)
+        }
+        return nil
+    })
+}
@@ -0,0 +1,154 @@
+/*
+*************************
+before
+uow.Do で UpdateStatus + Publish は原子的になったが、payment.Charge はDBトランザクションの
+外にいる。そのため：
+
+・クライアントがリクエストをリトライすると、Charge が2回実行され二重課金になりうる
+・Charge成功後にUpdateStatus/Publishが失敗すると、お金だけ取られて注文は pending のまま残る
+
+DBのロールバックでは外部APIの副作用は取り消せない。「取り消す」には明示的な補償処理が要る。
+*************************
+*/
+func (uc *CheckoutUsecase) Checkout(ctx context.Context, orderID string) error {
+    order, err := uc.orderRepo.Find(ctx, orderID)
+    if err != nil {
+        return NewInfraError("orderRepo.Find", err)
+    }
+
+    decision, err := order.DecideCheckout(uc.clock.Now())
+    if err != nil {
+        return err
+    }
+
+    if decision.NeedCharge {
+        // リトライされるたびにここが再実行され、二重課金になりうる
+        if err := uc.payment.Charge(ctx, order.CardToken, order.Amount); err != nil {
+            return NewInfraError("payment.Charge", err)
+        }
+    }
+
+    return uc.uow.Do(ctx, func(ctx context.Context) error {
+        if err := uc.orderRepo.UpdateStatus(ctx, order.ID, decision.NextStatus); err != nil {
+            // Chargeはもう成功しているのに、ここで失敗すると取り消す手段がない
+            return NewInfraError("orderRepo.UpdateStatus", err)
+        }
+        return uc.publisher.Publish(ctx, "order.paid", map[string]any{"order_id": order.ID})
+    })
+}
+
+/*
+外部APIの副作用はDBトランザクションに巻き込めない。なので：
+・同じリクエストの再送は IdempotencyStore で検出し、Chargeそのものをスキップする
+・Charge成功後に後続が失敗したら、Refund という補償アクションで打ち消す（サーガ）
+*************************
+after
+*************************
+*/
+
+// ChargeReceipt は Charge が成功した証跡。Refund のキーとしても使う
+type ChargeReceipt struct {
+    ID     string
+    Amount int64
+}
+
+// IdempotentPaymentGateway は通常の課金に加えて、サーガの補償としての返金を提供する
+type IdempotentPaymentGateway interface {
+    PaymentGateway
+    Refund(ctx context.Context, chargeID string, amount int64) error
+}
+
+type PaymentGateway interface {
+    Charge(ctx context.Context, cardToken string, amount int64) (ChargeReceipt, error)
+}
+
+// IdempotencyStore はキーごとに「処理済みかどうか」と結果を覚えておく場所。
+// Reserve は未処理なら予約し、処理済みならその結果を返す
+type IdempotencyStore interface {
+    Reserve(ctx context.Context, key string) (result ChargeReceipt, found bool, err error)
+    Commit(ctx context.Context, key string, result ChargeReceipt) error
+    // Invalidate はCommit済みの記録を取り消す。補償(Refund)したときに呼び、
+    // 次のリトライがRefund済みの古いChargeReceiptを見つけてしまうのを防ぐ
+    Invalidate(ctx context.Context, key string) error
+}
+
+type CheckoutCommand struct {
+    UserID         string
+    OrderID        string
+    Coupon         string
+    IdempotencyKey string
+}
+
+type CheckoutUsecase struct {
+    uow        UnitOfWork
+    orderRepo  OrderRepository
+    payment    IdempotentPaymentGateway
+    publisher  EventPublisher
+    clock      Clock
+    idempotent IdempotencyStore
+}
+
+func (uc *CheckoutUsecase) Checkout(ctx context.Context, cmd CheckoutCommand) error {
+    order, err := uc.orderRepo.Find(ctx, cmd.OrderID)
+    if err != nil {
+        return NewInfraError("orderRepo.Find", err)
+    }
+
+    decision, err := order.DecideCheckout(uc.clock.Now())
+    if err != nil {
+        return err
+    }
+
+    var receipt ChargeReceipt
+    if decision.NeedCharge {
+        receipt, err = uc.charge(ctx, cmd, order)
+        if err != nil {
+            return err
+        }
+    }
+
+    err = uc.uow.Do(ctx, func(ctx context.Context) error {
+        if err := uc.orderRepo.UpdateStatus(ctx, order.ID, decision.NextStatus); err != nil {
+            return NewInfraError("orderRepo.UpdateStatus", err)
+        }
+        return uc.publisher.Publish(ctx, "order.paid", map[string]any{"order_id": order.ID})
+    })
+    if err != nil && decision.NeedCharge {
+        // 課金は成功したが後続が失敗した。補償としてRefundし、予約も取り消す。
+        // Invalidateしないと、次のリトライがReserveでこの（返金済みの）ChargeReceiptを
+        // 見つけてしまい、再課金されないまま注文だけpaidになってしまう
+        if refundErr := uc.payment.Refund(ctx, receipt.ID, receipt.Amount); refundErr != nil {
+            return NewInfraError("payment.Refund (compensating)", refundErr)
+        }
+        if invalidateErr := uc.idempotent.Invalidate(ctx, cmd.IdempotencyKey); invalidateErr != nil {
+            return NewInfraError("idempotent.Invalidate (compensating)", invalidateErr)
+        }
+        return err
+    }
+    return err
+}
+
+// charge はリトライ時の二重課金を防ぐため、先にIdempotencyStoreを確認してから課金する
+func (uc *CheckoutUsecase) charge(ctx context.Context, cmd CheckoutCommand, order Order) (ChargeReceipt, error) {
+    if existing, found, err := uc.idempotent.Reserve(ctx, cmd.IdempotencyKey); err != nil {
+        return ChargeReceipt{}, NewInfraError("idempotent.Reserve", err)
+    } else if found {
+        return existing, nil
+    }
+
+    receipt, err := uc.payment.Charge(ctx, order.CardToken, order.Amount)
+    if err != nil {
+        return ChargeReceipt{}, NewInfraError("payment.Charge", err)
+    }
+
+    if err := uc.idempotent.Commit(ctx, cmd.IdempotencyKey, receipt); err != nil {
+        // Chargeはもう成功しているので、Commit失敗をそのまま返すだけだとuow.Doに
+        // 到達せず補償されない。それだと次のリトライがReserveでfound=falseを引いて
+        // 再課金してしまう（二重課金）ので、ここで明示的にRefundしておく
+        if refundErr := uc.payment.Refund(ctx, receipt.ID, receipt.Amount); refundErr != nil {
+            return ChargeReceipt{}, NewInfraError("payment.Refund (compensating, commit failure)", refundErr)
+        }
+        return ChargeReceipt{}, NewInfraError("idempotent.Commit", err)
+    }
+    return receipt, nil
+}
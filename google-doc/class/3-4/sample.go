@@ -0,0 +1,203 @@
+/*
+*************************
+before
+uow.Do の中で UpdateStatus と publisher.Publish をまとめても、Publish はDBとは別の
+ブローカー（Kafka等）に直接書きに行く処理。ブローカーが落ちていれば Publish は失敗し、
+uow.Do 全体がロールバックされて注文も pending に戻ってしまう。
+
+逆に、ロールバックしないようにPublishの失敗を握りつぶせば、今度は
+「注文は paid になったのに order.paid イベントは誰にも届かない」という
+サイレントな欠落が起きる。
+*************************
+*/
+func (uc *CheckoutUsecase) Checkout(ctx context.Context, orderID string) error {
+    order, err := uc.orderRepo.Find(ctx, orderID)
+    if err != nil {
+        return NewInfraError("orderRepo.Find", err)
+    }
+
+    decision, err := order.DecideCheckout(uc.clock.Now())
+    if err != nil {
+        return err
+    }
+
+    if decision.NeedCharge {
+        if _, err := uc.payment.Charge(ctx, order.CardToken, order.Amount); err != nil {
+            return NewInfraError("payment.Charge", err)
+        }
+    }
+
+    return uc.uow.Do(ctx, func(ctx context.Context) error {
+        if err := uc.orderRepo.UpdateStatus(ctx, order.ID, decision.NextStatus); err != nil {
+            return NewInfraError("orderRepo.UpdateStatus", err)
+        }
+        // ブローカー障害時にここだけ失敗し、状態更新ごとロールバックされるか
+        // 握りつぶされてイベントが消えるかの二択になってしまう
+        return uc.publisher.Publish(ctx, "order.paid", map[string]any{"order_id": order.ID})
+    })
+}
+
+/*
+「状態更新」と「配信予定として記録すること」はDBの中だけで完結させ、
+実際にブローカーへ届ける処理は別プロセス（Relay）に切り出す。
+これで UpdateStatus と「publishされるはず」は同じトランザクションでアトミックになり、
+ブローカーへの配送はリクエストの外で非同期にリトライできる。
+*************************
+after
+*************************
+*/
+
+// OutboxRepository は「配信すべきイベント」をDBの中に記録・取り出しするための層。
+// OrderRepository と同じトランザクションに載せられるのがポイント
+type OutboxRepository interface {
+    Enqueue(ctx context.Context, topic string, payload any) error
+    FetchPending(ctx context.Context, limit int) ([]OutboxMessage, error)
+    MarkPublished(ctx context.Context, id string) error
+}
+
+type OutboxMessage struct {
+    ID      string
+    Topic   string
+    Payload []byte
+}
+
+// txKey/txFromContext は 3-2 のUnitOfWorkが context に積む *sql.Tx を
+// 取り出すためのもの。SQLOutboxRepository はこれを使ってOrderRepositoryと
+// 同じTxに相乗りする
+type txKey struct{}
+
+func txFromContext(ctx context.Context) (*sql.Tx, bool) {
+    tx, ok := ctx.Value(txKey{}).(*sql.Tx)
+    return tx, ok
+}
+
+// SQLOutboxRepository は OutboxRepository の実装。uow.Do の中から呼ばれた場合は
+// context経由で渡された *sql.Tx にEnqueueし、TxOrderRepository(3-2)の
+// UpdateStatusと文字通り同じトランザクションに書き込む
+type SQLOutboxRepository struct {
+    db *sql.DB
+}
+
+func NewSQLOutboxRepository(db *sql.DB) *SQLOutboxRepository {
+    return &SQLOutboxRepository{db: db}
+}
+
+func (r *SQLOutboxRepository) Enqueue(ctx context.Context, topic string, payload any) error {
+    body, err := json.Marshal(payload)
+    if err != nil {
+        return NewInfraError("outbox.Enqueue.Marshal", err)
+    }
+
+    const q = `INSERT INTO outbox_messages (topic, payload, published) VALUES (?, ?, false)`
+    if tx, ok := txFromContext(ctx); ok {
+        _, err = tx.ExecContext(ctx, q, topic, body)
+    } else {
+        _, err = r.db.ExecContext(ctx, q, topic, body)
+    }
+    if err != nil {
+        return NewInfraError("outbox.Enqueue", err)
+    }
+    return nil
+}
+
+func (r *SQLOutboxRepository) FetchPending(ctx context.Context, limit int) ([]OutboxMessage, error) {
+    rows, err := r.db.QueryContext(ctx,
+        `SELECT id, topic, payload FROM outbox_messages WHERE published = false LIMIT ?`, limit)
+    if err != nil {
+        return nil, NewInfraError("outbox.FetchPending", err)
+    }
+    defer rows.Close()
+
+    var messages []OutboxMessage
+    for rows.Next() {
+        var m OutboxMessage
+        if err := rows.Scan(&m.ID, &m.Topic, &m.Payload); err != nil {
+            return nil, NewInfraError("outbox.FetchPending.Scan", err)
+        }
+        messages = append(messages, m)
+    }
+    return messages, nil
+}
+
+func (r *SQLOutboxRepository) MarkPublished(ctx context.Context, id string) error {
+    if _, err := r.db.ExecContext(ctx, `UPDATE outbox_messages SET published = true WHERE id = ?`, id); err != nil {
+        return NewInfraError("outbox.MarkPublished", err)
+    }
+    return nil
+}
+
+// OutboxPublisher は EventPublisher を実装するが、実際にはブローカーへ送らず
+// OutboxRepositoryに書き込むだけ。SQLOutboxRepositoryがcontext中のTxを
+// 使う実装なら、UpdateStatusと同じトランザクションに乗る
+type OutboxPublisher struct {
+    outbox OutboxRepository
+}
+
+func NewOutboxPublisher(outbox OutboxRepository) *OutboxPublisher {
+    return &OutboxPublisher{outbox: outbox}
+}
+
+func (p *OutboxPublisher) Publish(ctx context.Context, topic string, payload any) error {
+    return p.outbox.Enqueue(ctx, topic, payload)
+}
+
+func (uc *CheckoutUsecase) Checkout(ctx context.Context, orderID string) error {
+    order, err := uc.orderRepo.Find(ctx, orderID)
+    if err != nil {
+        return NewInfraError("orderRepo.Find", err)
+    }
+
+    decision, err := order.DecideCheckout(uc.clock.Now())
+    if err != nil {
+        return err
+    }
+
+    if decision.NeedCharge {
+        if _, err := uc.payment.Charge(ctx, order.CardToken, order.Amount); err != nil {
+            return NewInfraError("payment.Charge", err)
+        }
+    }
+
+    // UpdateStatusとEnqueueは同じトランザクション内のDB書き込みなので、
+    // どちらも成功するかどちらも失敗するかになる。ブローカーは関係ない
+    return uc.uow.Do(ctx, func(ctx context.Context) error {
+        if err := uc.orderRepo.UpdateStatus(ctx, order.ID, decision.NextStatus); err != nil {
+            return NewInfraError("orderRepo.UpdateStatus", err)
+        }
+        return uc.publisher.Publish(ctx, "order.paid", map[string]any{"order_id": order.ID})
+    })
+}
+
+// OutboxRelay はリクエスト経路の外で動くワーカー。未配信の行をポーリングして
+// 実際のブローカーへ転送し、成功したものだけMarkPublishedする
+type OutboxRelay struct {
+    outbox  OutboxRepository
+    broker  Broker
+    batchSz int
+}
+
+type Broker interface {
+    Send(ctx context.Context, topic string, payload []byte) error
+}
+
+func NewOutboxRelay(outbox OutboxRepository, broker Broker, batchSz int) *OutboxRelay {
+    return &OutboxRelay{outbox: outbox, broker: broker, batchSz: batchSz}
+}
+
+func (r *OutboxRelay) RelayOnce(ctx context.Context) error {
+    messages, err := r.outbox.FetchPending(ctx, r.batchSz)
+    if err != nil {
+        return NewInfraError("outbox.FetchPending", err)
+    }
+
+    for _, m := range messages {
+        if err := r.broker.Send(ctx, m.Topic, m.Payload); err != nil {
+            // 1件の失敗で全体を止めず、次回のポーリングでリトライさせる
+            continue
+        }
+        if err := r.outbox.MarkPublished(ctx, m.ID); err != nil {
+            return NewInfraError("outbox.MarkPublished", err)
+        }
+    }
+    return nil
+}
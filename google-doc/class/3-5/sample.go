@@ -0,0 +1,142 @@
+/*
+*************************
+before
+OrderRepository は Order 集約の状態遷移（Find/UpdateStatus）のためのインターフェースだが、
+画面表示やAPIレスポンス用に「注文一覧」「注文履歴」のような画面都合の形が欲しくなると、
+同じRepositoryに ListByUser や注文履歴用のJOINメソッドが足されがちになる。
+
+結果、OrderRepository は「Order集約を安全に読み書きするための最小インターフェース」では
+なくなり、表示都合のメソッドで肥大化していく。DTO/Mapper層も、わざわざOrderを
+組み立て直してから画面用の形に変換するという無駄な往復をする。
+*************************
+*/
+type OrderRepository interface {
+    Find(ctx context.Context, id string) (Order, error)
+    UpdateStatus(ctx context.Context, id string, status OrderStatus) error
+    // 表示都合のメソッドが書き込み側インターフェースに紛れ込んでいく
+    ListPaidOrdersByUser(ctx context.Context, userID string, offset, limit int) ([]Order, error)
+}
+
+type OrderDTO struct {
+    OrderID string `json:"order_id"`
+    Status  string `json:"status"`
+}
+
+func ToOrderDTOs(orders []Order) []OrderDTO {
+    dtos := make([]OrderDTO, 0, len(orders))
+    for _, o := range orders {
+        dtos = append(dtos, OrderDTO{OrderID: o.ID, Status: string(o.Status)})
+    }
+    return dtos
+}
+
+/*
+「集約としての整合性を守りながら状態遷移させる」ことと「画面に都合よく見せる」ことは
+別の関心事。書き込み側は Order 集約と DecideCheckout の不変条件に専念させ、
+読み取り側は別テーブルJOINやキャッシュ・リードレプリカを自由に使える
+OrderQueryServiceに分離する（CQRS）。
+*************************
+after
+*************************
+*/
+
+// 書き込み側：Order集約の状態遷移に必要な最小限のメソッドだけを持つ
+type OrderRepository interface {
+    Find(ctx context.Context, id string) (Order, error)
+    UpdateStatus(ctx context.Context, id string, status OrderStatus) error
+}
+
+// OrderSummaryView は一覧表示用の非正規化されたビュー
+type OrderSummaryView struct {
+    OrderID    string
+    UserName   string
+    Amount     int64
+    Status     string
+    PaidAt     time.Time
+}
+
+// OrderHistoryView は履歴画面用のビュー。Order集約には存在しない項目も持てる
+type OrderHistoryView struct {
+    OrderID     string
+    Status      string
+    StatusLabel string
+    EventLog    []string
+}
+
+// PagedOrderSummaries はページングされた一覧取得の結果
+type PagedOrderSummaries struct {
+    Items      []OrderSummaryView
+    TotalCount int
+}
+
+// 読み取り側：画面都合の形をそのまま返す。JOINやキャッシュ、リードレプリカの利用は
+// ここに閉じ込め、Order集約の不変条件には関与しない
+type OrderQueryService interface {
+    ListPaidOrdersByUser(ctx context.Context, userID string, offset, limit int) (PagedOrderSummaries, error)
+    GetOrderHistory(ctx context.Context, orderID string) (OrderHistoryView, error)
+}
+
+type SQLOrderQueryService struct {
+    db *sql.DB
+}
+
+func NewSQLOrderQueryService(db *sql.DB) *SQLOrderQueryService {
+    return &SQLOrderQueryService{db: db}
+}
+
+// ListPaidOrdersByUser は orders と users を直接JOINして一覧用のビューを返す。
+// Order集約を経由しないので、DecideCheckoutのような書き込み側の責務には触れない
+func (s *SQLOrderQueryService) ListPaidOrdersByUser(ctx context.Context, userID string, offset, limit int) (PagedOrderSummaries, error) {
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT o.id, u.name, o.amount, o.status, o.paid_at
+        FROM orders o
+        JOIN users u ON u.id = o.user_id
+        WHERE o.user_id = ? AND o.status = 'paid'
+        ORDER BY o.paid_at DESC
+        LIMIT ? OFFSET ?`, userID, limit, offset)
+    if err != nil {
+        return PagedOrderSummaries{}, NewInfraError("queryService.ListPaidOrdersByUser", err)
+    }
+    defer rows.Close()
+
+    var items []OrderSummaryView
+    for rows.Next() {
+        var v OrderSummaryView
+        if err := rows.Scan(&v.OrderID, &v.UserName, &v.Amount, &v.Status, &v.PaidAt); err != nil {
+            return PagedOrderSummaries{}, NewInfraError("queryService.ListPaidOrdersByUser.Scan", err)
+        }
+        items = append(items, v)
+    }
+
+    total, err := s.countPaidOrdersByUser(ctx, userID)
+    if err != nil {
+        return PagedOrderSummaries{}, err
+    }
+    return PagedOrderSummaries{Items: items, TotalCount: total}, nil
+}
+
+func (s *SQLOrderQueryService) countPaidOrdersByUser(ctx context.Context, userID string) (int, error) {
+    var total int
+    if err := s.db.QueryRowContext(ctx,
+        `SELECT COUNT(*) FROM orders WHERE user_id = ? AND status = 'paid'`, userID,
+    ).Scan(&total); err != nil {
+        return 0, NewInfraError("queryService.countPaidOrdersByUser", err)
+    }
+    return total, nil
+}
+
+func (s *SQLOrderQueryService) GetOrderHistory(ctx context.Context, orderID string) (OrderHistoryView, error) {
+    var v OrderHistoryView
+    // 実際にはevent_logテーブルなどをJOINするが、ここでは型だけ示す
+    return v, nil
+}
+
+// DTO/Mapper層はOrderQueryServiceのビューをそのまま使い、
+// Order集約を作り直す必要がなくなる
+func ToOrderSummaryDTOs(views []OrderSummaryView) []OrderDTO {
+    dtos := make([]OrderDTO, 0, len(views))
+    for _, v := range views {
+        dtos = append(dtos, OrderDTO{OrderID: v.OrderID, Status: v.Status})
+    }
+    return dtos
+}
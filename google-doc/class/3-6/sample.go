@@ -0,0 +1,133 @@
+/*
+*************************
+before
+期限切れチェックの条件を変えたい（有効期限の猶予を厳しくする）、クーポンを
+複数枚重ねられるようにしたい、本人確認済みユーザーだけ許可したい、といった
+ルール変更が来るたびに DecideCheckout を直接書き換えている。
+
+PRの差分は「既存の分岐を書き換える」形になり、古い挙動がどうだったかは
+git blame を遡らないとわからない。ロールバックしたくなっても
+また手で書き戻す必要がある。
+*************************
+*/
+func (o Order) DecideCheckout(now time.Time) (CheckoutDecision, error) {
+    if o.Status != StatusPending {
+        return CheckoutDecision{}, NewDomainError(Conflict, "注文は決済待ち状態ではありません", nil)
+    }
+    // 新しいルールがこの条件式自体を書き換える形で入ってくる
+    if now.After(o.ExpireAt) {
+        return CheckoutDecision{}, NewDomainError(Conflict, "注文の有効期限が切れています", nil)
+    }
+    return CheckoutDecision{NextStatus: StatusPaid, NeedCharge: true}, nil
+}
+
+/*
+新しいルールを既存コードの書き換えではなく「追加」として入れられるようにする。
+FeatureFlagsで新旧の判断関数を切り替えられるようにしておけば、PRは
+既存コードに触れない純粋な追加になり、問題が起きてもフラグを戻すだけで済む。
+*************************
+after
+*************************
+*/
+
+// FeatureFlags はリリースフラグの照会だけを行う。判定の実装（どのユーザーに
+// 有効化するか等）はこのインターフェースの外側に隠す
+type FeatureFlags interface {
+    IsEnabled(ctx context.Context, name string) bool
+}
+
+// StaticFlags はテスト・ローカル確認用の実装。名前→有効/無効の固定マップを持つだけ
+type StaticFlags struct {
+    enabled map[string]bool
+}
+
+func NewStaticFlags(enabled map[string]bool) *StaticFlags {
+    return &StaticFlags{enabled: enabled}
+}
+
+func (f *StaticFlags) IsEnabled(_ context.Context, name string) bool {
+    return f.enabled[name]
+}
+
+const flagStrictExpiry = "checkout.strict_expiry_window"
+
+// DecideCheckout は従来どおりの判断。既存のPRはここを書き換えない
+func (o Order) DecideCheckout(now time.Time) (CheckoutDecision, error) {
+    if o.Status != StatusPending {
+        return CheckoutDecision{}, NewDomainError(Conflict, "注文は決済待ち状態ではありません", nil)
+    }
+    if now.After(o.ExpireAt) {
+        return CheckoutDecision{}, NewDomainError(Conflict, "注文の有効期限が切れています", nil)
+    }
+    return CheckoutDecision{NextStatus: StatusPaid, NeedCharge: true}, nil
+}
+
+// DecideCheckoutV2 は「有効期限切れの猶予を厳しくする」新ルールを追加した版。
+// 旧ルール（DecideCheckout）はそのまま残す。フラグの判定はこの関数の中で行い、
+// フラグが無効なら DecideCheckout と同じ判定になる
+func (o Order) DecideCheckoutV2(ctx context.Context, now time.Time, flags FeatureFlags) (CheckoutDecision, error) {
+    if o.Status != StatusPending {
+        return CheckoutDecision{}, NewDomainError(Conflict, "注文は決済待ち状態ではありません", nil)
+    }
+
+    expireAt := o.ExpireAt
+    if flags.IsEnabled(ctx, flagStrictExpiry) {
+        // 新ルール：ExpireAt の strictExpiryGrace（10分）前を期限とし、より厳しく判定する
+        expireAt = o.ExpireAt.Add(-strictExpiryGrace)
+    }
+    if now.After(expireAt) {
+        return CheckoutDecision{}, NewDomainError(Conflict, "注文の有効期限が切れています", nil)
+    }
+    return CheckoutDecision{NextStatus: StatusPaid, NeedCharge: true}, nil
+}
+
+const strictExpiryGrace = 10 * time.Minute
+
+// applyDiscountV2 はクーポンの重ね掛けを許可する新ルール。旧applyDiscountは
+// そのまま残す。フラグはこの関数の中で判定し、無効なら先頭のクーポン1枚だけを
+// 旧applyDiscountで適用し、有効なら全クーポンを順に適用する
+func applyDiscountV2(ctx context.Context, amount int64, coupons []string, flags FeatureFlags) int64 {
+    if !flags.IsEnabled(ctx, "checkout.coupon_stacking") {
+        if len(coupons) > 0 {
+            return applyDiscount(amount, coupons[0])
+        }
+        return amount
+    }
+    for _, c := range coupons {
+        amount = applyDiscount(amount, c)
+    }
+    return amount
+}
+
+type CheckoutUsecase struct {
+    orderRepo OrderRepository
+    payment   PaymentGateway
+    publisher EventPublisher
+    clock     Clock
+    flags     FeatureFlags
+}
+
+// Checkout はDecideCheckoutV2を呼ぶが、新旧どちらのルールが適用されるかは
+// FeatureFlagsの設定だけで決まる。このusecase自体はルールの中身を知らない
+func (uc *CheckoutUsecase) Checkout(ctx context.Context, orderID string) error {
+    order, err := uc.orderRepo.Find(ctx, orderID)
+    if err != nil {
+        return NewInfraError("orderRepo.Find", err)
+    }
+
+    decision, err := order.DecideCheckoutV2(ctx, uc.clock.Now(), uc.flags)
+    if err != nil {
+        return err
+    }
+
+    if decision.NeedCharge {
+        if _, err := uc.payment.Charge(ctx, order.CardToken, order.Amount); err != nil {
+            return NewInfraError("payment.Charge", err)
+        }
+    }
+
+    if err := uc.orderRepo.UpdateStatus(ctx, order.ID, decision.NextStatus); err != nil {
+        return NewInfraError("orderRepo.UpdateStatus", err)
+    }
+    return uc.publisher.Publish(ctx, "order.paid", map[string]any{"order_id": order.ID})
+}